@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// buildConfig describes a matrix of (dist, arch) sysroots to build in one
+// invocation, e.g.:
+//
+//	dists: [bookworm, trixie]
+//	components: [main, contrib]
+//	architectures: [amd64, arm64]
+//	packages:
+//	  - name: libc6
+//	  - name: libgl1
+//	    arch: [amd64]   # only pulled in for amd64 images
+type buildConfig struct {
+	Dists         []string      `yaml:"dists"`
+	Components    []string      `yaml:"components"`
+	Architectures []string      `yaml:"architectures"`
+	Packages      []packageSpec `yaml:"packages"`
+}
+
+type packageSpec struct {
+	Name string   `yaml:"name"`
+	Arch []string `yaml:"arch,omitempty"`
+}
+
+// forArch returns the package names applicable to arch: those with no Arch
+// override, plus those whose override list includes arch.
+func (c *buildConfig) forArch(arch string) []string {
+	var names []string
+	for _, p := range c.Packages {
+		if len(p.Arch) == 0 {
+			names = append(names, p.Name)
+			continue
+		}
+		for _, a := range p.Arch {
+			if a == arch {
+				names = append(names, p.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+func loadBuildConfig(path string) (*buildConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errorf(err.Error())
+	}
+	c := &buildConfig{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, errorf(err.Error())
+	}
+	if len(c.Components) == 0 {
+		c.Components = []string{"main"}
+	}
+	return c, nil
+}
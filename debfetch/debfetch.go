@@ -0,0 +1,367 @@
+// Package debfetch fetches and unpacks Debian packages directly from an
+// archive mirror, without shelling out to apt-get or dpkg-deb.
+package debfetch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// Config describes the mirror and suite to fetch packages from.
+type Config struct {
+	Mirror     string   // e.g. http://deb.debian.org/debian
+	Dist       string   // e.g. bookworm
+	Components []string // e.g. main, contrib
+	Arch       string   // e.g. amd64
+	CacheDir   string   // content-addressed download cache
+	KeyringPath string  // path to an armored OpenPGP keyring used to verify InRelease
+}
+
+// Package is a single entry from a Packages index.
+type Package struct {
+	Name     string
+	Version  string
+	Arch     string
+	Filename string
+	SHA256   string
+	Depends  string
+	PreDepends string
+	Provides string
+	Conflicts string
+	Essential bool
+}
+
+// Fetcher resolves and downloads packages from a single (dist, arch) suite.
+type Fetcher struct {
+	cfg   Config
+	index map[string]*Package
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{} // SHA256 -> closed once that .deb lands in the cache
+}
+
+// New fetches the suite's InRelease file, verifying its signature against
+// cfg.KeyringPath when one is set (an empty KeyringPath skips the check), and
+// loads the Packages indices for every configured component.
+func New(ctx context.Context, cfg Config) (*Fetcher, error) {
+	if cfg.CacheDir == "" {
+		return nil, fmt.Errorf("debfetch: cache dir is required")
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("debfetch: create cache dir: %v", err)
+	}
+
+	f := &Fetcher{cfg: cfg, index: map[string]*Package{}, inflight: map[string]chan struct{}{}}
+
+	raw, err := f.fetchVerified(ctx, "dists/"+cfg.Dist+"/InRelease")
+	if err != nil {
+		return nil, fmt.Errorf("debfetch: fetch InRelease: %v", err)
+	}
+	release, err := parseRelease(raw)
+	if err != nil {
+		return nil, fmt.Errorf("debfetch: parse InRelease: %v", err)
+	}
+
+	for _, component := range cfg.Components {
+		relIndexPath := path.Join(component, "binary-"+cfg.Arch, "Packages.gz")
+		pkgsPath := path.Join("dists", cfg.Dist, relIndexPath)
+		body, err := f.get(ctx, pkgsPath)
+		if err != nil {
+			return nil, fmt.Errorf("debfetch: fetch %v: %v", pkgsPath, err)
+		}
+		raw, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("debfetch: read %v: %v", pkgsPath, err)
+		}
+		want, ok := release.Digests[relIndexPath]
+		if !ok {
+			return nil, fmt.Errorf("debfetch: %v: not listed in the verified InRelease file", relIndexPath)
+		}
+		if got := sha256.Sum256(raw); hex.EncodeToString(got[:]) != want {
+			return nil, fmt.Errorf("debfetch: %v: sha256 mismatch against InRelease, got %v want %v", relIndexPath, hex.EncodeToString(got[:]), want)
+		}
+		if err := f.parsePackages(bytes.NewReader(raw)); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// fetchVerified downloads relPath and checks its detached OpenPGP signature
+// (the InRelease file is clearsigned) against cfg.KeyringPath. If
+// KeyringPath is empty the file is returned as fetched, unverified.
+func (f *Fetcher) fetchVerified(ctx context.Context, relPath string) ([]byte, error) {
+	body, err := f.get(ctx, relPath)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.cfg.KeyringPath != "" {
+		keyring, err := os.Open(f.cfg.KeyringPath)
+		if err != nil {
+			return nil, fmt.Errorf("open keyring: %v", err)
+		}
+		defer keyring.Close()
+		entities, err := openpgp.ReadArmoredKeyRing(keyring)
+		if err != nil {
+			return nil, fmt.Errorf("read keyring: %v", err)
+		}
+		block, _ := clearsign.Decode(raw)
+		if block == nil {
+			return nil, fmt.Errorf("%v is not clearsigned", relPath)
+		}
+		if _, err := openpgp.CheckDetachedSignature(entities, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+			return nil, fmt.Errorf("verify signature: %v", err)
+		}
+		raw = block.Bytes
+	}
+
+	return raw, nil
+}
+
+// parseRelease parses an InRelease/Release control file into its identity
+// fields and the SHA256 digest of every file it covers, keyed by the path
+// relative to the dist directory (e.g. "main/binary-amd64/Packages.gz").
+// These digests let New cross-check each fetched Packages.gz against the
+// release (signature-verified when a keyring is configured).
+func parseRelease(raw []byte) (*Release, error) {
+	rel := &Release{Digests: map[string]string{}}
+
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	inSHA256 := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if !inSHA256 {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			rel.Digests[fields[2]] = fields[0]
+			continue
+		}
+		inSHA256 = false
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "Origin":
+			rel.Origin = strings.TrimSpace(v)
+		case "Suite":
+			rel.Suite = strings.TrimSpace(v)
+		case "SHA256":
+			inSHA256 = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// get issues a GET against cfg.Mirror+relPath and returns the response body.
+func (f *Fetcher) get(ctx context.Context, relPath string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(f.cfg.Mirror, "/")+"/"+relPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%v: %v", relPath, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// parsePackages gunzips and reads an RFC822-style Packages.gz index and
+// merges its entries into f.index. A package present in more than one
+// configured component (e.g. a main/contrib overlap, or components that
+// share an in-flight archive transition) keeps whichever entry has the newer
+// version; f.index holds one entry per package name, so older versions are
+// not kept.
+func (f *Fetcher) parsePackages(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("gunzip Packages: %v", err)
+	}
+	defer gz.Close()
+
+	fields := map[string]string{}
+	flush := func() {
+		if fields["Package"] == "" {
+			return
+		}
+		p := &Package{
+			Name:       fields["Package"],
+			Version:    fields["Version"],
+			Arch:       fields["Architecture"],
+			Filename:   fields["Filename"],
+			SHA256:     fields["SHA256"],
+			Depends:    fields["Depends"],
+			PreDepends: fields["Pre-Depends"],
+			Provides:   fields["Provides"],
+			Conflicts:  fields["Conflicts"],
+			Essential:  fields["Essential"] == "yes",
+		}
+		if existing, ok := f.index[p.Name]; !ok || CompareVersions(p.Version, existing.Version) > 0 {
+			f.index[p.Name] = p
+		}
+		fields = map[string]string{}
+	}
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var lastKey string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			fields[lastKey] += "\n" + line
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		lastKey = strings.TrimSpace(k)
+		fields[lastKey] = strings.TrimSpace(v)
+	}
+	flush()
+	return scanner.Err()
+}
+
+// Lookup returns the indexed Package metadata for name.
+func (f *Fetcher) Lookup(name string) (*Package, bool) {
+	p, ok := f.index[name]
+	return p, ok
+}
+
+// All returns every package in the loaded indices, for callers (such as
+// depsolve) that need to build a reverse Provides: index.
+func (f *Fetcher) All() []*Package {
+	all := make([]*Package, 0, len(f.index))
+	for _, p := range f.index {
+		all = append(all, p)
+	}
+	return all
+}
+
+// Fetch downloads pkg's .deb into the content-addressed cache, verifying its
+// SHA256 against the Packages index, and returns the local path. Concurrent
+// callers racing on the same SHA256 (common when a worker pool fetches
+// several packages that share a dependency) block on one another instead of
+// writing the same .tmp file twice.
+func (f *Fetcher) Fetch(ctx context.Context, pkg *Package) (string, error) {
+	if pkg.SHA256 == "" {
+		return "", fmt.Errorf("debfetch: %v has no SHA256 in Packages index", pkg.Name)
+	}
+	dest := filepath.Join(f.cfg.CacheDir, pkg.SHA256[:2], pkg.SHA256+".deb")
+
+	for {
+		if _, err := os.Stat(dest); err == nil {
+			return dest, nil
+		}
+
+		f.mu.Lock()
+		if done, ok := f.inflight[pkg.SHA256]; ok {
+			f.mu.Unlock()
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		f.inflight[pkg.SHA256] = done
+		f.mu.Unlock()
+
+		path, err := f.download(ctx, pkg, dest)
+
+		f.mu.Lock()
+		delete(f.inflight, pkg.SHA256)
+		f.mu.Unlock()
+		close(done)
+
+		return path, err
+	}
+}
+
+// download does the actual fetch-verify-rename of pkg into dest; callers
+// must hold pkg.SHA256's inflight slot.
+func (f *Fetcher) download(ctx context.Context, pkg *Package, dest string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+
+	body, err := f.get(ctx, pkg.Filename)
+	if err != nil {
+		return "", fmt.Errorf("debfetch: download %v: %v", pkg.Name, err)
+	}
+	defer body.Close()
+
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), body); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	out.Close()
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if sum != pkg.SHA256 {
+		os.Remove(tmp)
+		return "", fmt.Errorf("debfetch: %v: sha256 mismatch, got %v want %v", pkg.Name, sum, pkg.SHA256)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dest, nil
+}
+
+// Release is the subset of an InRelease file deb2aci cares about: its
+// identity fields and the SHA256 digest of every index file it lists.
+type Release struct {
+	Origin  string
+	Suite   string
+	Digests map[string]string // path relative to the dist dir -> sha256 hex
+}
@@ -0,0 +1,118 @@
+package debfetch
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blakesmith/ar"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Unpack extracts the data.tar.* member of the .deb at debPath into destDir,
+// preserving symlinks, ownership and mode. destDir must already exist.
+//
+// Only data.tar.* is extracted; control.tar.*'s maintainer scripts
+// (preinst/postinst/prerm/postrm) are never read or executed, on this or
+// any other architecture — deb2aci has always produced static filesystem
+// trees, never run packages' install-time scripts.
+func Unpack(debPath, destDir string) error {
+	f, err := os.Open(debPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := ar.NewReader(f)
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			return fmt.Errorf("debfetch: %v has no data.tar.* member", debPath)
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(hdr.Name, "data.tar") {
+			continue
+		}
+		tr, err := decompress(hdr.Name, reader)
+		if err != nil {
+			return err
+		}
+		return extractTar(tr, destDir)
+	}
+}
+
+// decompress wraps r with the decompressor matching the data.tar.* suffix.
+func decompress(name string, r io.Reader) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return r, nil
+	case strings.HasSuffix(name, ".tar.gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(name, ".tar.xz"):
+		return xz.NewReader(r)
+	case strings.HasSuffix(name, ".tar.zst"):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("debfetch: unsupported data archive %v", name)
+	}
+}
+
+// extractTar writes every regular file, directory and symlink in tr into
+// destDir, keeping the original mode and, where possible, ownership.
+func extractTar(tr io.Reader, destDir string) error {
+	t := tar.NewReader(tr)
+	for {
+		hdr, err := t.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destDir, filepath.Clean("/"+hdr.Name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			if err := os.Link(filepath.Join(destDir, filepath.Clean("/"+hdr.Linkname)), target); err != nil {
+				return err
+			}
+		default:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, t); err != nil {
+				out.Close()
+				return err
+			}
+			out.Close()
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+			os.Chown(target, hdr.Uid, hdr.Gid)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package debfetch
+
+import "strings"
+
+// CompareVersions compares two Debian package versions ([epoch:]upstream[-revision])
+// per the algorithm in Debian Policy 5.6.12 and returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	ea, ua, ra := splitVersion(a)
+	eb, ub, rb := splitVersion(b)
+
+	if c := compareNumeric(ea, eb); c != 0 {
+		return c
+	}
+	if c := compareComponent(ua, ub); c != 0 {
+		return c
+	}
+	return compareComponent(ra, rb)
+}
+
+func splitVersion(v string) (epoch, upstream, revision string) {
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		epoch, v = v[:i], v[i+1:]
+	} else {
+		epoch = "0"
+	}
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		upstream, revision = v[:i], v[i+1:]
+	} else {
+		upstream, revision = v, "0"
+	}
+	return
+}
+
+func compareNumeric(a, b string) int {
+	for len(a) > 1 && a[0] == '0' {
+		a = a[1:]
+	}
+	for len(b) > 1 && b[0] == '0' {
+		b = b[1:]
+	}
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareComponent implements dpkg's version comparison: alternating runs of
+// non-digits (compared lexically, with '~' sorting before everything,
+// including the empty string) and digits (compared numerically).
+func compareComponent(a, b string) int {
+	for len(a) > 0 || len(b) > 0 {
+		na, ra := takeNonDigits(a)
+		nb, rb := takeNonDigits(b)
+		if c := compareLexical(na, nb); c != 0 {
+			return c
+		}
+		a, b = ra, rb
+
+		da, ra := takeDigits(a)
+		db, rb := takeDigits(b)
+		if c := compareNumeric(da, db); c != 0 {
+			return c
+		}
+		a, b = ra, rb
+	}
+	return 0
+}
+
+func takeDigits(s string) (digits, rest string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		return "0", s
+	}
+	return s[:i], s[i:]
+}
+
+func takeNonDigits(s string) (nonDigits, rest string) {
+	i := 0
+	for i < len(s) && !(s[i] >= '0' && s[i] <= '9') {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// order ranks a rune for dpkg's lexical comparison: '~' is lowest, then end
+// of string, then letters, then everything else, in byte order.
+func order(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c == 0:
+		return 0
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+func compareLexical(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var ca, cb byte
+		if i < len(a) {
+			ca = a[i]
+		}
+		if i < len(b) {
+			cb = b[i]
+		}
+		if oa, ob := order(ca), order(cb); oa != ob {
+			if oa < ob {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package debfetch
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0", "1.0", 1},
+		{"1:1.0", "2.0", 1}, // higher epoch wins regardless of upstream
+		{"1.0", "1:0.1", -1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-2", "1.0-1", 1},
+		{"1.0-1", "1.0", 1},    // explicit revision beats implicit "0"
+		{"1.0~rc1", "1.0", -1}, // ~ sorts before the empty string
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0", "1.0a", -1}, // end of string sorts before a letter
+		{"7.0", "10.0", -1}, // numeric, not lexical, comparison
+		{"1.0.0", "1.0.0", 0},
+		{"2:1.0", "1:9.9", 1},
+	}
+	for _, c := range cases {
+		if got := CompareVersions(c.a, c.b); got != c.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestCompareVersionsAntisymmetric(t *testing.T) {
+	pairs := [][2]string{
+		{"1.0", "2.0"},
+		{"1:1.0", "2.0"},
+		{"1.0~rc1", "1.0"},
+		{"1.0-1", "1.0-2"},
+	}
+	for _, p := range pairs {
+		fwd := CompareVersions(p[0], p[1])
+		rev := CompareVersions(p[1], p[0])
+		if fwd != -rev {
+			t.Errorf("CompareVersions(%q, %q) = %d, CompareVersions(%q, %q) = %d, want negation", p[0], p[1], fwd, p[1], p[0], rev)
+		}
+	}
+}
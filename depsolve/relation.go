@@ -0,0 +1,140 @@
+// Package depsolve parses Debian dependency relationships and resolves a
+// requested package set against a Packages index, honoring versions,
+// alternatives, virtual packages (Provides:) and Conflicts:.
+package depsolve
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/klizhentas/deb2aci/debfetch"
+)
+
+// Constraint is a single "name (op version) [arch]" term.
+type Constraint struct {
+	Name    string
+	Arch    string // arch qualifier, e.g. "amd64" in "libc6:amd64", empty if none
+	Op      string // one of <<, <=, =, >=, >>, empty if unconstrained
+	Version string
+}
+
+// Alternative is one "|"-separated option within a relation.
+type Alternative []Constraint
+
+// Relation is a full comma-separated Depends/Pre-Depends/Conflicts field.
+type Relation []Alternative
+
+// ParseRelation parses a Depends-style field, e.g.
+// "libc6 (>= 2.17) | libc6-compat, libssl3 (>= 3.0.0)".
+func ParseRelation(field string) (Relation, error) {
+	field = strings.TrimSpace(field)
+	if field == "" {
+		return nil, nil
+	}
+	var rel Relation
+	for _, group := range splitTop(field, ',') {
+		group = strings.TrimSpace(group)
+		if group == "" {
+			continue
+		}
+		var alt Alternative
+		for _, term := range splitTop(group, '|') {
+			c, err := parseConstraint(strings.TrimSpace(term))
+			if err != nil {
+				return nil, err
+			}
+			alt = append(alt, c)
+		}
+		rel = append(rel, alt)
+	}
+	return rel, nil
+}
+
+// splitTop splits s on sep, ignoring occurrences inside parentheses.
+func splitTop(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		default:
+			if s[i] == sep && depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+	return parts
+}
+
+var versionOps = []string{"<<", "<=", "=", ">=", ">>"}
+
+// parseConstraint parses a single "name (op version) [arch]" term.
+func parseConstraint(term string) (Constraint, error) {
+	c := Constraint{}
+
+	if i := strings.IndexByte(term, '['); i >= 0 {
+		j := strings.IndexByte(term, ']')
+		if j < i {
+			return c, fmt.Errorf("depsolve: malformed arch qualifier in %q", term)
+		}
+		term = strings.TrimSpace(term[:i] + term[j+1:])
+	}
+
+	if i := strings.IndexByte(term, '('); i >= 0 {
+		j := strings.IndexByte(term, ')')
+		if j < i {
+			return c, fmt.Errorf("depsolve: malformed version constraint in %q", term)
+		}
+		name := strings.TrimSpace(term[:i])
+		constraint := strings.TrimSpace(term[i+1 : j])
+
+		var op string
+		for _, candidate := range versionOps {
+			if strings.HasPrefix(constraint, candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return c, fmt.Errorf("depsolve: unknown version operator in %q", term)
+		}
+		c.Name, c.Op, c.Version = name, op, strings.TrimSpace(constraint[len(op):])
+	} else {
+		c.Name = strings.TrimSpace(term)
+	}
+
+	if name, arch, ok := strings.Cut(c.Name, ":"); ok {
+		c.Name, c.Arch = name, arch
+	}
+	if c.Name == "" {
+		return c, fmt.Errorf("depsolve: empty package name in %q", term)
+	}
+	return c, nil
+}
+
+// Satisfies reports whether candidateVersion satisfies c's version operator.
+func (c Constraint) Satisfies(candidateVersion string) bool {
+	if c.Op == "" {
+		return true
+	}
+	cmp := debfetch.CompareVersions(candidateVersion, c.Version)
+	switch c.Op {
+	case "<<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "=":
+		return cmp == 0
+	case ">=":
+		return cmp >= 0
+	case ">>":
+		return cmp > 0
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package depsolve
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRelationSimple(t *testing.T) {
+	rel, err := ParseRelation("libc6 (>= 2.17) | libc6-compat, libssl3 (>= 3.0.0)")
+	if err != nil {
+		t.Fatalf("ParseRelation: %v", err)
+	}
+	want := Relation{
+		Alternative{
+			{Name: "libc6", Op: ">=", Version: "2.17"},
+			{Name: "libc6-compat"},
+		},
+		Alternative{
+			{Name: "libssl3", Op: ">=", Version: "3.0.0"},
+		},
+	}
+	if !reflect.DeepEqual(rel, want) {
+		t.Errorf("ParseRelation = %+v, want %+v", rel, want)
+	}
+}
+
+func TestParseRelationEmpty(t *testing.T) {
+	rel, err := ParseRelation("")
+	if err != nil {
+		t.Fatalf("ParseRelation: %v", err)
+	}
+	if rel != nil {
+		t.Errorf("ParseRelation(\"\") = %+v, want nil", rel)
+	}
+}
+
+func TestParseRelationArchQualifier(t *testing.T) {
+	rel, err := ParseRelation("libc6:amd64 (>= 2.17) [amd64 arm64]")
+	if err != nil {
+		t.Fatalf("ParseRelation: %v", err)
+	}
+	want := Relation{Alternative{{Name: "libc6", Arch: "amd64", Op: ">=", Version: "2.17"}}}
+	if !reflect.DeepEqual(rel, want) {
+		t.Errorf("ParseRelation = %+v, want %+v", rel, want)
+	}
+}
+
+func TestParseRelationCommaInsideParens(t *testing.T) {
+	// Some archives use a comma-separated alternative-version hint inside
+	// the parens; splitTop must not treat it as a new Depends entry.
+	rel, err := ParseRelation("foo (>= 1.0, 2.0)")
+	if err != nil {
+		t.Fatalf("ParseRelation: %v", err)
+	}
+	if len(rel) != 1 || len(rel[0]) != 1 {
+		t.Fatalf("ParseRelation = %+v, want a single alternative", rel)
+	}
+	if rel[0][0].Name != "foo" || rel[0][0].Op != ">=" {
+		t.Errorf("ParseRelation term = %+v, want Name=foo Op=>=", rel[0][0])
+	}
+}
+
+func TestParseRelationMalformed(t *testing.T) {
+	cases := []string{
+		"foo (>= 1.0", // unterminated paren
+		"foo (1.0)",   // no recognized operator
+		"foo [amd64",  // unterminated bracket
+		"(>= 1.0)",    // no package name
+	}
+	for _, c := range cases {
+		if _, err := ParseRelation(c); err == nil {
+			t.Errorf("ParseRelation(%q) = nil error, want error", c)
+		}
+	}
+}
+
+func TestConstraintSatisfies(t *testing.T) {
+	cases := []struct {
+		c    Constraint
+		v    string
+		want bool
+	}{
+		{Constraint{}, "1.0", true}, // unconstrained
+		{Constraint{Op: ">=", Version: "2.0"}, "2.0", true},
+		{Constraint{Op: ">=", Version: "2.0"}, "1.9", false},
+		{Constraint{Op: "<<", Version: "2.0"}, "1.9", true},
+		{Constraint{Op: "<<", Version: "2.0"}, "2.0", false},
+		{Constraint{Op: "=", Version: "1.0-1"}, "1.0-1", true},
+		{Constraint{Op: "=", Version: "1.0-1"}, "1.0-2", false},
+		{Constraint{Op: ">>", Version: "1.0"}, "1.0", false},
+	}
+	for _, c := range cases {
+		if got := c.c.Satisfies(c.v); got != c.want {
+			t.Errorf("%+v.Satisfies(%q) = %v, want %v", c.c, c.v, got, c.want)
+		}
+	}
+}
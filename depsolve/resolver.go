@@ -0,0 +1,205 @@
+package depsolve
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/klizhentas/deb2aci/debfetch"
+)
+
+// Resolver picks a consistent set of packages (and their transitive
+// dependencies) out of a debfetch.Fetcher's loaded indices.
+type Resolver struct {
+	fetcher   *debfetch.Fetcher
+	exclude   map[string]bool
+	providers map[string][]string // virtual package name -> providing package names
+	selected  map[string]*debfetch.Package
+}
+
+// NewResolver builds the reverse Provides: index over everything the
+// fetcher has loaded. Names in exclude are treated as already satisfied.
+func NewResolver(fetcher *debfetch.Fetcher, exclude []string) *Resolver {
+	r := &Resolver{
+		fetcher:   fetcher,
+		exclude:   map[string]bool{},
+		providers: map[string][]string{},
+		selected:  map[string]*debfetch.Package{},
+	}
+	for _, name := range exclude {
+		r.exclude[name] = true
+	}
+	for _, p := range fetcher.All() {
+		rel, err := ParseRelation(p.Provides)
+		if err != nil {
+			continue
+		}
+		for _, alt := range rel {
+			for _, c := range alt {
+				r.providers[c.Name] = append(r.providers[c.Name], p.Name)
+			}
+		}
+	}
+	return r
+}
+
+// Resolve returns every package needed to satisfy names, in the order they
+// were selected: each package comes before the dependencies it pulled in. If essential is true the release's Essential:yes packages are
+// seeded first, as apt would. It returns a readable error trace on
+// unsatisfiable constraints or conflicts.
+func (r *Resolver) Resolve(names []string, essential bool) ([]*debfetch.Package, error) {
+	var order []*debfetch.Package
+
+	var want []Constraint
+	if essential {
+		for _, p := range r.fetcher.All() {
+			if p.Essential {
+				want = append(want, Constraint{Name: p.Name})
+			}
+		}
+	}
+	for _, n := range names {
+		want = append(want, Constraint{Name: n})
+	}
+
+	for _, c := range want {
+		pkg, err := r.resolveOne(Alternative{c}, []string{c.Name})
+		if err != nil {
+			return nil, err
+		}
+		if pkg == nil {
+			continue // excluded
+		}
+		if err := r.add(pkg, &order, []string{c.Name}); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// add selects pkg (if not already selected), checks it against Conflicts:
+// of everything selected so far, and recurses into its Depends:/Pre-Depends:.
+func (r *Resolver) add(pkg *debfetch.Package, order *[]*debfetch.Package, trace []string) error {
+	if _, ok := r.selected[pkg.Name]; ok {
+		return nil
+	}
+	if err := r.checkConflicts(pkg, trace); err != nil {
+		return err
+	}
+	r.selected[pkg.Name] = pkg
+	*order = append(*order, pkg)
+
+	for _, field := range []string{pkg.PreDepends, pkg.Depends} {
+		rel, err := ParseRelation(field)
+		if err != nil {
+			return fmt.Errorf("depsolve: %v: %v", pkg.Name, err)
+		}
+		for _, alt := range rel {
+			next := append(append([]string{}, trace...), altNames(alt))
+			resolved, err := r.resolveOne(alt, next)
+			if err != nil {
+				return err
+			}
+			if resolved == nil {
+				continue
+			}
+			if err := r.add(resolved, order, next); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveOne picks a single candidate package for an alternative ("a | b"),
+// preferring a package that is already selected (either directly or as a
+// provider), then falling back to the newest candidate satisfying any of
+// the alternative's constraints. Providers, selected or not, are taken
+// without checking the constraint's version. Returns (nil, nil) as soon as
+// one of the alternative's names, or a provider of one, is excluded via
+// --exclude, since excluded names count as already satisfied.
+func (r *Resolver) resolveOne(alt Alternative, trace []string) (*debfetch.Package, error) {
+	for _, c := range alt {
+		if r.exclude[c.Name] {
+			return nil, nil
+		}
+		if p, ok := r.selected[c.Name]; ok && c.Satisfies(p.Version) {
+			return p, nil
+		}
+		for _, providerName := range r.providers[c.Name] {
+			if r.exclude[providerName] {
+				return nil, nil
+			}
+			if p, ok := r.selected[providerName]; ok {
+				return p, nil
+			}
+		}
+	}
+
+	var best *debfetch.Package
+	for _, c := range alt {
+		if p, ok := r.fetcher.Lookup(c.Name); ok && c.Satisfies(p.Version) {
+			if best == nil || debfetch.CompareVersions(p.Version, best.Version) > 0 {
+				best = p
+			}
+		}
+		for _, providerName := range r.providers[c.Name] {
+			if p, ok := r.fetcher.Lookup(providerName); ok {
+				if best == nil || debfetch.CompareVersions(p.Version, best.Version) > 0 {
+					best = p
+				}
+			}
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("depsolve: unsatisfiable dependency %v (via %v)",
+			altNames(alt), strings.Join(trace, " -> "))
+	}
+	return best, nil
+}
+
+// checkConflicts errors if pkg conflicts with anything already selected, or
+// vice versa.
+func (r *Resolver) checkConflicts(pkg *debfetch.Package, trace []string) error {
+	pkgConflicts, err := ParseRelation(pkg.Conflicts)
+	if err != nil {
+		return fmt.Errorf("depsolve: %v: %v", pkg.Name, err)
+	}
+	for _, other := range r.selected {
+		if conflictsWith(pkgConflicts, other) {
+			return fmt.Errorf("depsolve: %v conflicts with already-selected %v (via %v)",
+				pkg.Name, other.Name, strings.Join(trace, " -> "))
+		}
+		otherConflicts, err := ParseRelation(other.Conflicts)
+		if err != nil {
+			continue
+		}
+		for _, alt := range otherConflicts {
+			for _, c := range alt {
+				if c.Name == pkg.Name && c.Satisfies(pkg.Version) {
+					return fmt.Errorf("depsolve: %v conflicts with already-selected %v (via %v)",
+						other.Name, pkg.Name, strings.Join(trace, " -> "))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func conflictsWith(rel Relation, other *debfetch.Package) bool {
+	for _, alt := range rel {
+		for _, c := range alt {
+			if c.Name == other.Name && c.Satisfies(other.Version) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func altNames(alt Alternative) string {
+	names := make([]string, len(alt))
+	for i, c := range alt {
+		names[i] = c.Name
+	}
+	return strings.Join(names, " | ")
+}
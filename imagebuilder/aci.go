@@ -0,0 +1,162 @@
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+// ACIBuilder produces an appc/ACI image via `actool build`, the original
+// deb2aci output format.
+type ACIBuilder struct {
+	// Epoch clamps the mtime of every file and directory (not symlinks),
+	// for reproducible builds.
+	Epoch int64
+}
+
+func (b *ACIBuilder) Build(ctx context.Context, layers []Layer, m *schema.ImageManifest, imagePath string) error {
+	dir, err := ioutil.TempDir("", "deb2aci-aci")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return err
+	}
+
+	for _, l := range layers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := copyTree(l.Rootfs, rootfs, b.Epoch); err != nil {
+			return err
+		}
+		i, err := types.SanitizeACIdentifier(fmt.Sprintf("debian.org/deb/%v", l.Name))
+		if err != nil {
+			return err
+		}
+		a, err := types.NewACIdentifier(i)
+		if err != nil {
+			return err
+		}
+		m.Annotations.Set(*a, fmt.Sprintf("%v/%v", l.Arch, l.Version))
+	}
+	// Architecture "all" packages say nothing about the target, so label
+	// with the first architecture-specific one.
+	for _, l := range layers {
+		if l.Arch != "all" {
+			if err := setArchLabels(m, l.Arch); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	bytes, err := m.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "manifest"), bytes, 0644); err != nil {
+		return err
+	}
+	return runCmd(exec.CommandContext(ctx, "actool", "build", "-overwrite", dir, imagePath))
+}
+
+// copyTree merges src into dst, preserving symlinks and file modes and
+// clamping mtimes to epoch, in place of the previous `cp -a` shell-out.
+// Symlinks keep the mtime they were created with.
+func copyTree(src, dst string, epoch int64) error {
+	clamped := time.Unix(epoch, 0)
+	var dirs []string
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		}
+		if info.IsDir() {
+			if err := os.MkdirAll(target, info.Mode()); err != nil {
+				return err
+			}
+			dirs = append(dirs, target)
+			return nil
+		}
+
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if _, err := io.Copy(out, in); err != nil {
+			return err
+		}
+		return os.Chtimes(target, clamped, clamped)
+	})
+	if err != nil {
+		return err
+	}
+	// Directories are clamped last: copying entries into a directory resets
+	// its mtime.
+	for _, d := range dirs {
+		if err := os.Chtimes(d, clamped, clamped); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCmd runs cmd, draining its stdout/stderr to ours and waiting for both
+// copiers to finish before waiting on cmd, so cmd.Wait never closes the
+// pipes while a drain goroutine is still reading them.
+func runCmd(cmd *exec.Cmd) error {
+	slog.Info("run", "path", cmd.Path, "args", cmd.Args)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); io.Copy(os.Stdout, stdout) }()
+	go func() { defer wg.Done(); io.Copy(os.Stderr, stderr) }()
+
+	wg.Wait()
+	return cmd.Wait()
+}
@@ -0,0 +1,155 @@
+package imagebuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/appc/spec/schema"
+)
+
+// DockerBuilder writes a Docker v2 image tarball at imagePath, loadable via
+// `docker load -i`.
+type DockerBuilder struct {
+	// Epoch clamps every layer entry's mtime, for reproducible digests.
+	Epoch int64
+}
+
+type dockerLayerJSON struct {
+	ID           string `json:"id"`
+	Parent       string `json:"parent,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	OS           string `json:"os,omitempty"`
+}
+
+type dockerManifestEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags,omitempty"`
+	Layers   []string `json:"Layers"`
+}
+
+func (b *DockerBuilder) Build(ctx context.Context, layers []Layer, m *schema.ImageManifest, imagePath string) error {
+	f, err := os.Create(imagePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	defer tw.Close()
+
+	var layerPaths []string
+	var diffIDs []string
+	var history []ociHistory
+	var arch, parent string
+
+	for _, l := range layers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var raw bytes.Buffer
+		if err := writeLayerTar(l.Rootfs, &raw, b.Epoch); err != nil {
+			return fmt.Errorf("imagebuilder: tar %v: %v", l.Name, err)
+		}
+		id := sha256sum(raw.Bytes())
+		diffIDs = append(diffIDs, "sha256:"+id)
+		history = append(history, ociHistory{
+			CreatedBy: fmt.Sprintf("deb2aci: installed %v %v", l.Name, l.Version),
+		})
+		if arch == "" {
+			arch = l.Arch
+		}
+
+		layerJSON, err := json.Marshal(dockerLayerJSON{
+			ID: id, Parent: parent, Architecture: arch, OS: "linux",
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := addTarFile(tw, id+"/VERSION", []byte("1.0"), b.Epoch); err != nil {
+			return err
+		}
+		if err := addTarFile(tw, id+"/json", layerJSON, b.Epoch); err != nil {
+			return err
+		}
+		if err := addTarFile(tw, id+"/layer.tar", raw.Bytes(), b.Epoch); err != nil {
+			return err
+		}
+
+		layerPaths = append(layerPaths, id+"/layer.tar")
+		parent = id
+	}
+
+	cfg := ociConfig{
+		Architecture: arch,
+		OS:           "linux",
+		Rootfs:       ociRootfs{Type: "layers", DiffIDs: diffIDs},
+		History:      history,
+	}
+	cfgBytes, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	cfgDigest := sha256sum(cfgBytes)
+	cfgName := cfgDigest + ".json"
+	if err := addTarFile(tw, cfgName, cfgBytes, b.Epoch); err != nil {
+		return err
+	}
+
+	repoTag := repoTagFor(m)
+	manifest := []dockerManifestEntry{{
+		Config:   cfgName,
+		RepoTags: []string{repoTag},
+		Layers:   layerPaths,
+	}}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := addTarFile(tw, "manifest.json", manifestBytes, b.Epoch); err != nil {
+		return err
+	}
+
+	repo, tag := splitRepoTag(repoTag)
+	repositories := map[string]map[string]string{repo: {tag: parent}}
+	repositoriesBytes, err := json.Marshal(repositories)
+	if err != nil {
+		return err
+	}
+	return addTarFile(tw, "repositories", repositoriesBytes, b.Epoch)
+}
+
+func addTarFile(tw *tar.Writer, name string, data []byte, epoch int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Unix(epoch, 0),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// repoTagFor derives a docker repo:tag from the image manifest's name,
+// defaulting to "deb2aci:latest".
+func repoTagFor(m *schema.ImageManifest) string {
+	if m != nil && m.Name != "" {
+		return string(m.Name) + ":latest"
+	}
+	return "deb2aci:latest"
+}
+
+func splitRepoTag(repoTag string) (repo, tag string) {
+	for i := len(repoTag) - 1; i >= 0; i-- {
+		if repoTag[i] == ':' {
+			return repoTag[:i], repoTag[i+1:]
+		}
+	}
+	return repoTag, "latest"
+}
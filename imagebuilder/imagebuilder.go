@@ -0,0 +1,42 @@
+// Package imagebuilder turns a set of unpacked Debian packages into a
+// container image, in one of several output formats.
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/appc/spec/schema"
+)
+
+// Layer is a single resolved package's unpacked contents, as produced by
+// debfetch.Unpack.
+type Layer struct {
+	Name    string
+	Version string
+	Arch    string
+	Rootfs  string
+}
+
+// Builder writes layers out as an image at imagePath, in whatever format it
+// implements. ctx is checked between layers so a cancelled build (Ctrl-C, or
+// --timeout) stops without finishing the remaining layers.
+type Builder interface {
+	Build(ctx context.Context, layers []Layer, manifest *schema.ImageManifest, imagePath string) error
+}
+
+// New returns the Builder for format, one of "aci" (the default), "oci" or
+// "docker". sourceDateEpoch clamps every file's timestamps so repeated
+// builds of the same inputs produce byte-identical output.
+func New(format string, sourceDateEpoch int64) (Builder, error) {
+	switch format {
+	case "", "aci":
+		return &ACIBuilder{Epoch: sourceDateEpoch}, nil
+	case "oci":
+		return &OCIBuilder{Epoch: sourceDateEpoch}, nil
+	case "docker":
+		return &DockerBuilder{Epoch: sourceDateEpoch}, nil
+	default:
+		return nil, fmt.Errorf("imagebuilder: unknown format %q, want aci, oci or docker", format)
+	}
+}
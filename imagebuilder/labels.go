@@ -0,0 +1,33 @@
+package imagebuilder
+
+import (
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+// setArchLabels records the target architecture and OS as appc's reserved
+// "os"/"arch" labels, so a multi-arch build's images are distinguishable
+// once unpacked. It leaves any label the caller already set alone.
+func setArchLabels(m *schema.ImageManifest, arch string) error {
+	have := map[string]bool{}
+	for _, l := range m.Labels {
+		have[string(l.Name)] = true
+	}
+
+	add := func(name, value string) error {
+		if have[name] {
+			return nil
+		}
+		id, err := types.NewACIdentifier(name)
+		if err != nil {
+			return err
+		}
+		m.Labels = append(m.Labels, types.Label{Name: *id, Value: value})
+		return nil
+	}
+
+	if err := add("os", "linux"); err != nil {
+		return err
+	}
+	return add("arch", arch)
+}
@@ -0,0 +1,188 @@
+package imagebuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/appc/spec/schema"
+)
+
+const (
+	ociMediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	ociMediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// OCIBuilder writes an OCI image-spec v1 layout, one layer per resolved
+// package, to a directory at imagePath.
+type OCIBuilder struct {
+	// Epoch clamps every layer entry's mtime, for reproducible digests.
+	Epoch int64
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type ociRootfs struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type ociHistory struct {
+	CreatedBy string `json:"created_by"`
+}
+
+type ociConfig struct {
+	Architecture string       `json:"architecture"`
+	OS           string       `json:"os"`
+	Rootfs       ociRootfs    `json:"rootfs"`
+	History      []ociHistory `json:"history"`
+}
+
+func (b *OCIBuilder) Build(ctx context.Context, layers []Layer, m *schema.ImageManifest, imagePath string) error {
+	blobsDir := filepath.Join(imagePath, "blobs", "sha256")
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return err
+	}
+
+	var layerDescs []ociDescriptor
+	var diffIDs []string
+	var history []ociHistory
+	var arch string
+
+	for _, l := range layers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var raw bytes.Buffer
+		if err := writeLayerTar(l.Rootfs, &raw, b.Epoch); err != nil {
+			return fmt.Errorf("imagebuilder: tar %v: %v", l.Name, err)
+		}
+		diffID := sha256sum(raw.Bytes())
+		diffIDs = append(diffIDs, "sha256:"+diffID)
+
+		var compressed bytes.Buffer
+		gw := gzip.NewWriter(&compressed)
+		gw.ModTime = time.Unix(b.Epoch, 0)
+		if _, err := gw.Write(raw.Bytes()); err != nil {
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return err
+		}
+
+		digest, size, err := writeBlob(blobsDir, compressed.Bytes())
+		if err != nil {
+			return err
+		}
+		layerDescs = append(layerDescs, ociDescriptor{
+			MediaType: ociMediaTypeLayer,
+			Digest:    "sha256:" + digest,
+			Size:      size,
+		})
+		history = append(history, ociHistory{
+			CreatedBy: fmt.Sprintf("deb2aci: installed %v %v", l.Name, l.Version),
+		})
+		if arch == "" {
+			arch = l.Arch
+		}
+	}
+
+	cfg := ociConfig{
+		Architecture: arch,
+		OS:           "linux",
+		Rootfs:       ociRootfs{Type: "layers", DiffIDs: diffIDs},
+		History:      history,
+	}
+	cfgBytes, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	cfgDigest, cfgSize, err := writeBlob(blobsDir, cfgBytes)
+	if err != nil {
+		return err
+	}
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeManifest,
+		Config: ociDescriptor{
+			MediaType: ociMediaTypeConfig,
+			Digest:    "sha256:" + cfgDigest,
+			Size:      cfgSize,
+		},
+		Layers:      layerDescs,
+		Annotations: translateAnnotations(m),
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestDigest, manifestSize, err := writeBlob(blobsDir, manifestBytes)
+	if err != nil {
+		return err
+	}
+
+	index := map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests": []ociDescriptor{{
+			MediaType: ociMediaTypeManifest,
+			Digest:    "sha256:" + manifestDigest,
+			Size:      manifestSize,
+		}},
+	}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(imagePath, "index.json"), indexBytes, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(imagePath, "oci-layout"),
+		[]byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+}
+
+// translateAnnotations maps the appc ImageManifest's annotations onto the
+// OCI org.opencontainers.image.* namespace.
+func translateAnnotations(m *schema.ImageManifest) map[string]string {
+	out := map[string]string{}
+	for _, ann := range m.Annotations {
+		out["org.opencontainers.image."+string(ann.Name)] = ann.Value
+	}
+	return out
+}
+
+func sha256sum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeBlob writes b to dir content-addressed by its SHA256 and returns the
+// digest and size.
+func writeBlob(dir string, b []byte) (digest string, size int64, err error) {
+	digest = sha256sum(b)
+	if err := ioutil.WriteFile(filepath.Join(dir, digest), b, 0644); err != nil {
+		return "", 0, err
+	}
+	return digest, int64(len(b)), nil
+}
@@ -0,0 +1,83 @@
+package imagebuilder
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// writeLayerTar writes every file under rootfs into w as an uncompressed
+// tar stream, with paths relative to rootfs and walked in sorted order, and
+// every entry's timestamps clamped to epoch and ownership stripped, so
+// repeated builds of the same rootfs produce byte-identical output.
+func writeLayerTar(rootfs string, w io.Writer, epoch int64) error {
+	var paths []string
+	if err := filepath.Walk(rootfs, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == rootfs {
+			return nil
+		}
+		paths = append(paths, p)
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	tw := tar.NewWriter(w)
+	for _, p := range paths {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootfs, p)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		clamped := time.Unix(epoch, 0).UTC()
+		hdr.ModTime = clamped
+		hdr.AccessTime = clamped
+		hdr.ChangeTime = clamped
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/klizhentas/deb2aci/debfetch"
+)
+
+// lockEntry pins a single resolved package to an exact version and .deb
+// SHA256, the way --from-lockfile downloads are verified against the
+// mirror's current Packages index.
+type lockEntry struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	SHA256  string `json:"sha256"`
+}
+
+type lockfile struct {
+	Packages []lockEntry `json:"packages"`
+}
+
+// writeLockfile records the resolved set so a later --from-lockfile run can
+// reproduce it exactly.
+func writeLockfile(path string, resolved []*debfetch.Package) error {
+	lf := lockfile{}
+	for _, p := range resolved {
+		lf.Packages = append(lf.Packages, lockEntry{
+			Name:    p.Name,
+			Version: p.Version,
+			Arch:    p.Arch,
+			SHA256:  p.SHA256,
+		})
+	}
+	b, err := json.MarshalIndent(lf, "", "  ")
+	if err != nil {
+		return errorf(err.Error())
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+func readLockfile(path string) (*lockfile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errorf(err.Error())
+	}
+	lf := &lockfile{}
+	if err := json.Unmarshal(b, lf); err != nil {
+		return nil, errorf(err.Error())
+	}
+	return lf, nil
+}
+
+// pin resolves each lockfile entry against the fetcher's current index,
+// failing if the mirror no longer offers the exact pinned version/SHA256.
+func (lf *lockfile) pin(fetcher *debfetch.Fetcher) ([]*debfetch.Package, error) {
+	var resolved []*debfetch.Package
+	for _, e := range lf.Packages {
+		p, ok := fetcher.Lookup(e.Name)
+		if !ok {
+			return nil, errorf("%v: pinned in lockfile but not found on mirror", e.Name)
+		}
+		if p.Version != e.Version || p.Arch != e.Arch || p.SHA256 != e.SHA256 {
+			return nil, errorf(
+				"%v: mirror now offers %v/%v (sha256 %v), lockfile pins %v/%v (sha256 %v)",
+				e.Name, p.Version, p.Arch, p.SHA256, e.Version, e.Arch, e.SHA256)
+		}
+		resolved = append(resolved, p)
+	}
+	return resolved, nil
+}
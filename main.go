@@ -1,34 +1,41 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strings"
-
-	"github.com/klizhentas/deb2aci/Godeps/_workspace/src/github.com/appc/spec/schema"
-	"github.com/klizhentas/deb2aci/Godeps/_workspace/src/github.com/appc/spec/schema/types"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/klizhentas/deb2aci/debfetch"
+	"github.com/klizhentas/deb2aci/depsolve"
+	"github.com/klizhentas/deb2aci/imagebuilder"
 )
 
-type pkgs []string
+// stringList implements flag.Value for repeatable string flags, e.g.
+// -pkg foo -pkg bar.
+type stringList []string
 
-func (p *pkgs) String() string {
-	return fmt.Sprintf("%v", *p)
+func (l *stringList) String() string {
+	return fmt.Sprintf("%v", *l)
 }
 
-func (p *pkgs) Set(value string) error {
-	*p = append(*p, value)
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
 
 func main() {
-	var pkgs pkgs
+	var pkgs stringList
 	flag.Var(&pkgs, "pkg", "list of packages to download")
 
 	var image string
@@ -37,32 +44,173 @@ func main() {
 	var manifestPath string
 	flag.StringVar(&manifestPath, "manifest", "", "manifest")
 
+	var mirror string
+	flag.StringVar(&mirror, "mirror", "http://deb.debian.org/debian", "debian mirror to fetch packages from")
+
+	var dist string
+	flag.StringVar(&dist, "dist", "stable", "release to fetch packages from, e.g. bookworm")
+
+	var components stringList
+	flag.Var(&components, "component", "archive component to index, e.g. main (repeatable, defaults to main)")
+
+	var arch string
+	flag.StringVar(&arch, "arch", "amd64", "architecture to fetch packages for")
+
+	var keyring string
+	flag.StringVar(&keyring, "keyring", "", "path to an armored OpenPGP keyring used to verify the release file")
+
+	var cache string
+	flag.StringVar(&cache, "cache", filepath.Join(os.TempDir(), "deb2aci-cache"), "content-addressed cache directory for downloaded .deb files")
+
+	var exclude stringList
+	flag.Var(&exclude, "exclude", "package to leave out of the image, e.g. one already in a base layer (repeatable)")
+
+	var essential bool
+	flag.BoolVar(&essential, "essential", false, "seed the resolver with the release's Essential:yes packages")
+
+	var format string
+	flag.StringVar(&format, "format", "aci", "output image format: aci, oci or docker")
+
+	var sourceDateEpoch int64
+	flag.Int64Var(&sourceDateEpoch, "source-date-epoch", defaultSourceDateEpoch(), "unix timestamp clamped onto every file for reproducible builds, falls back to $SOURCE_DATE_EPOCH")
+
+	var lockfilePath string
+	flag.StringVar(&lockfilePath, "lockfile", "", "write the resolved package set (name, version, arch, sha256) to this path")
+
+	var fromLockfile string
+	flag.StringVar(&fromLockfile, "from-lockfile", "", "skip resolution and build exactly the packages pinned in this lockfile")
+
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "YAML build matrix (dists x architectures x packages); when set, -pkg/-dist/-arch/-component are ignored and one image is produced per (dist,arch) pair")
+
+	var jobs int
+	flag.IntVar(&jobs, "jobs", 4, "number of packages to fetch concurrently")
+
+	var timeout time.Duration
+	flag.DurationVar(&timeout, "timeout", 0, "abort the whole run if it hasn't finished after this long, e.g. 10m (0 disables)")
+
 	if len(os.Args) < 3 {
-		log.Fatalf("deb2aci: package package package manifest")
+		fatal("deb2aci: package package package manifest")
 		return
 	}
 	flag.Parse()
-	if len(pkgs) == 0 {
-		log.Fatalf("supply at least one package")
-	}
 	if len(image) == 0 {
-		log.Fatalf("provide an image name")
+		fatal("provide an image name")
 	}
-
-	log.Printf(
-		"deb2aci: will convert packages %v and archive to %v", pkgs, image)
 	image, err := filepath.Abs(image)
 	if err != nil {
-		log.Fatalf("err: %v", err)
+		fatal("err: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
+	}
+
+	if configPath != "" {
+		cfg, err := loadBuildConfig(configPath)
+		if err != nil {
+			fatal("deb2aci: ERROR: %v", err)
+		}
+		if err := buildMatrix(ctx, cfg, matrixOptions{
+			image:           image,
+			manifestPath:    manifestPath,
+			mirror:          mirror,
+			keyring:         keyring,
+			cache:           cache,
+			exclude:         exclude,
+			essential:       essential,
+			format:          format,
+			sourceDateEpoch: sourceDateEpoch,
+			jobs:            jobs,
+		}); err != nil {
+			fatal("deb2aci: ERROR: %v", err)
+		}
+		return
+	}
+
+	if len(pkgs) == 0 {
+		fatal("supply at least one package")
 	}
+	slog.Info("will convert", "packages", []string(pkgs), "image", image)
 	manifest, err := readManifest(manifestPath)
 	if err != nil {
-		log.Fatalf(err.Error())
+		fatal(err.Error())
+	}
+
+	if len(components) == 0 {
+		components = stringList{"main"}
+	}
+	fetcher, err := debfetch.New(ctx, debfetch.Config{
+		Mirror:      mirror,
+		Dist:        dist,
+		Components:  components,
+		Arch:        arch,
+		CacheDir:    cache,
+		KeyringPath: keyring,
+	})
+	if err != nil {
+		fatal("deb2aci: ERROR: %v", err)
+	}
+
+	builder, err := imagebuilder.New(format, sourceDateEpoch)
+	if err != nil {
+		fatal("deb2aci: ERROR: %v", err)
+	}
+
+	var resolved []*debfetch.Package
+	if fromLockfile != "" {
+		lf, err := readLockfile(fromLockfile)
+		if err != nil {
+			fatal("deb2aci: ERROR: %v", err)
+		}
+		resolved, err = lf.pin(fetcher)
+		if err != nil {
+			fatal("deb2aci: ERROR: %v", err)
+		}
+	} else {
+		resolver := depsolve.NewResolver(fetcher, exclude)
+		resolved, err = resolver.Resolve(pkgs, essential)
+		if err != nil {
+			fatal("deb2aci: ERROR: %v", err)
+		}
+	}
+	slog.Info("resolved", "count", len(resolved))
+
+	if lockfilePath != "" {
+		if err := writeLockfile(lockfilePath, resolved); err != nil {
+			fatal("deb2aci: ERROR: %v", err)
+		}
+	}
+
+	if err := convert(ctx, fetcher, builder, resolved, image, manifest, jobs); err != nil {
+		fatal("deb2aci: ERROR: %v", err)
 	}
-	if err := convert(pkgs, image, manifest); err != nil {
-		log.Fatalf("deb2aci: ERROR: %v", err)
+	slog.Info("here you go", "image", image)
+}
+
+// fatal logs msg at error level and exits 1, in place of log.Fatalf now that
+// main uses slog (which has no built-in Fatal).
+func fatal(format string, args ...interface{}) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// defaultSourceDateEpoch reads $SOURCE_DATE_EPOCH, defaulting to 0 (the Unix
+// epoch) if unset or unparseable.
+func defaultSourceDateEpoch() int64 {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return 0
+	}
+	epoch, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0
 	}
-	log.Printf("deb2aci: here you go: %v", image)
+	return epoch
 }
 
 func readManifest(path string) (*schema.ImageManifest, error) {
@@ -77,7 +225,11 @@ func readManifest(path string) (*schema.ImageManifest, error) {
 	return &i, nil
 }
 
-func convert(pkgs []string, image string, manifest *schema.ImageManifest) error {
+// convert fetches and unpacks every resolved package, then hands the
+// resulting layers to builder. Fetches run on a pool of jobs workers; ctx
+// cancellation (Ctrl-C or --timeout) stops outstanding fetches and skips the
+// build.
+func convert(ctx context.Context, fetcher *debfetch.Fetcher, builder imagebuilder.Builder, resolved []*debfetch.Package, image string, manifest *schema.ImageManifest, jobs int) error {
 	dir, err := ioutil.TempDir("", "deb2aci")
 	if err != nil {
 		return err
@@ -85,137 +237,69 @@ func convert(pkgs []string, image string, manifest *schema.ImageManifest) error
 
 	defer func() {
 		if err := os.RemoveAll(dir); err != nil {
-			log.Printf("deb2aci: failed to remove %v, err: %v", dir, err)
+			slog.Warn("failed to remove temp dir", "dir", dir, "err", err)
 		}
 	}()
 
-	fs := make(map[string]*deb)
-	for _, pkg := range pkgs {
-		if err := download(pkg, dir, fs); err != nil {
-			return err
-		}
+	if jobs < 1 {
+		jobs = 1
 	}
-	return createACI(dir, fs, image, manifest)
-}
+	layers := make([]imagebuilder.Layer, len(resolved))
+	errs := make([]error, len(resolved))
 
-func createACI(dir string, fs map[string]*deb, image string, m *schema.ImageManifest) error {
-	idir, err := ioutil.TempDir(dir, "image")
-	if err != nil {
-		return errorf(err.Error())
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, pkg := range resolved {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, pkg *debfetch.Package) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			layers[i], errs[i] = fetchAndUnpack(ctx, fetcher, pkg, dir)
+		}(i, pkg)
 	}
-	rootfs := filepath.Join(idir, "rootfs")
-	os.MkdirAll(rootfs, 0755)
+	wg.Wait()
 
-	for _, d := range fs {
-		err := run(exec.Command("cp", "-a", d.Path+"/.", rootfs))
+	for _, err := range errs {
 		if err != nil {
 			return err
 		}
-		i, err := types.SanitizeACIdentifier(
-			fmt.Sprintf("debian.org/deb/%v", d.Name))
-		if err != nil {
-			return errorf(err.Error())
-		}
-		a, err := types.NewACIdentifier(i)
-		if err != nil {
-			return errorf(err.Error())
-		}
-		m.Annotations.Set(
-			*a, fmt.Sprintf("%v/%v", d.Arch, d.Version))
-	}
-	bytes, err := m.MarshalJSON()
-	if err != nil {
-		return errorf(err.Error())
-	}
-	if err := ioutil.WriteFile(filepath.Join(idir, "manifest"), bytes, 0644); err != nil {
-		return errorf(err.Error())
-	}
-	if err := run(exec.Command("actool", "build", "-overwrite", idir, image)); err != nil {
-		return err
-	}
-	return nil
-}
-
-func download(pkg, dir string, done map[string]*deb) error {
-	log.Printf("downloading %v to %v", pkg, dir)
-
-	if done[pkg] != nil {
-		log.Printf("%v already downloaded, returning", pkg)
-		return nil
-	}
-
-	tdir, err := ioutil.TempDir(dir, "pkg")
-	if err != nil {
-		return err
 	}
-	os.Chdir(tdir)
 
-	err = run(exec.Command("apt-get", "download", pkg))
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	return builder.Build(ctx, layers, manifest, image)
+}
 
-	matches, err := filepath.Glob(filepath.Join(tdir, "*.deb"))
-	if err != nil || len(matches) != 1 {
-		return errorf("unexpected: %v %v", err, matches)
-	}
-	debName := matches[0]
-	// now unpack the archive to the folder
-	err = run(exec.Command(
-		"dpkg-deb", "-x", debName, filepath.Join(tdir, "out")))
-	if err != nil {
-		return err
-	}
+// fetchAndUnpack downloads a single resolved package's .deb and unpacks it
+// into its own directory under dir.
+func fetchAndUnpack(ctx context.Context, fetcher *debfetch.Fetcher, pkg *debfetch.Package, dir string) (imagebuilder.Layer, error) {
+	slog.Info("fetching", "package", pkg.Name, "version", pkg.Version)
 
-	arch, err := output("dpkg-deb", "-f", debName, "Architecture")
+	debPath, err := fetcher.Fetch(ctx, pkg)
 	if err != nil {
-		return err
+		return imagebuilder.Layer{}, err
 	}
 
-	ver, err := output("dpkg-deb", "-f", debName, "Version")
+	tdir, err := ioutil.TempDir(dir, "pkg")
 	if err != nil {
-		return err
+		return imagebuilder.Layer{}, err
 	}
-
-	done[pkg] = &deb{
-		Name:    pkg,
-		Path:    filepath.Join(tdir, "out"),
-		Arch:    arch,
-		Version: ver,
-	}
-
-	// now list all dependencies
-	out, err := output("dpkg-deb", "-f", debName, "Depends")
-	if err != nil {
-		return err
+	out := filepath.Join(tdir, "out")
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return imagebuilder.Layer{}, err
 	}
-	deps := parseDeps(string(out))
-	if len(deps) != 0 {
-		log.Printf("%v depends on %#v, downloading deps", pkg, deps)
-		for _, d := range deps {
-			if err := download(d, dir, done); err != nil {
-				return err
-			}
-		}
+	if err := debfetch.Unpack(debPath, out); err != nil {
+		return imagebuilder.Layer{}, err
 	}
-	return nil
-}
 
-func parseDeps(line string) []string {
-	line = strings.TrimSpace(line)
-	if len(line) == 0 {
-		return nil
-	}
-	parts := strings.Split(line, ",")
-	if len(parts) == 0 {
-		return nil
-	}
-	deps := make([]string, len(parts))
-	for i, p := range parts {
-		o := strings.Split(strings.TrimSpace(p), " ")
-		deps[i] = o[0]
-	}
-	return deps
+	return imagebuilder.Layer{
+		Name:    pkg.Name,
+		Version: pkg.Version,
+		Arch:    pkg.Arch,
+		Rootfs:  out,
+	}, nil
 }
 
 func errorf(format string, args ...interface{}) error {
@@ -250,33 +334,3 @@ type Err struct {
 func (e *Err) Error() string {
 	return fmt.Sprintf("[%v:%v] %v", e.File, e.Line, e.Message)
 }
-
-func output(cmd string, args ...string) (string, error) {
-	out, err := exec.Command(cmd, args...).CombinedOutput()
-	if err != nil {
-		return "", errorf("%v: %v", out, err.Error())
-	}
-	return strings.TrimSpace(string(out)), nil
-}
-
-func run(cmd *exec.Cmd) error {
-	log.Printf("run: %v %v", cmd.Path, cmd.Args)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return errorf(err.Error())
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return errorf(err.Error())
-	}
-	go io.Copy(os.Stdout, stdout)
-	go io.Copy(os.Stderr, stderr)
-	return cmd.Run()
-}
-
-type deb struct {
-	Name    string
-	Path    string
-	Version string
-	Arch    string
-}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/klizhentas/deb2aci/debfetch"
+	"github.com/klizhentas/deb2aci/depsolve"
+	"github.com/klizhentas/deb2aci/imagebuilder"
+)
+
+// matrixOptions carries the flags that apply uniformly to every (dist,arch)
+// build driven by --config.
+type matrixOptions struct {
+	image           string
+	manifestPath    string
+	mirror          string
+	keyring         string
+	cache           string
+	exclude         []string
+	essential       bool
+	format          string
+	sourceDateEpoch int64
+	jobs            int
+}
+
+// buildMatrix fans cfg out into one image per (dist,arch) pair, sharing a
+// single download cache across all of them. It stops at the first (dist,arch)
+// pair still in flight when ctx is cancelled.
+func buildMatrix(ctx context.Context, cfg *buildConfig, opts matrixOptions) error {
+	if len(cfg.Dists) == 0 || len(cfg.Architectures) == 0 {
+		return errorf("config must list at least one dist and one architecture")
+	}
+
+	for _, dist := range cfg.Dists {
+		for _, arch := range cfg.Architectures {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			pkgs := cfg.forArch(arch)
+			if len(pkgs) == 0 {
+				slog.Info("no packages apply, skipping", "dist", dist, "arch", arch)
+				continue
+			}
+
+			image := suffixImagePath(opts.image, dist, arch)
+			slog.Info("building", "dist", dist, "arch", arch, "image", image)
+
+			manifest, err := readManifest(opts.manifestPath)
+			if err != nil {
+				return err
+			}
+
+			fetcher, err := debfetch.New(ctx, debfetch.Config{
+				Mirror:      opts.mirror,
+				Dist:        dist,
+				Components:  cfg.Components,
+				Arch:        arch,
+				CacheDir:    opts.cache,
+				KeyringPath: opts.keyring,
+			})
+			if err != nil {
+				return fmt.Errorf("%v/%v: %v", dist, arch, err)
+			}
+
+			builder, err := imagebuilder.New(opts.format, opts.sourceDateEpoch)
+			if err != nil {
+				return err
+			}
+
+			resolver := depsolve.NewResolver(fetcher, opts.exclude)
+			resolved, err := resolver.Resolve(pkgs, opts.essential)
+			if err != nil {
+				return fmt.Errorf("%v/%v: %v", dist, arch, err)
+			}
+			slog.Info("resolved", "dist", dist, "arch", arch, "count", len(resolved))
+
+			if err := convert(ctx, fetcher, builder, resolved, image, manifest, opts.jobs); err != nil {
+				return fmt.Errorf("%v/%v: %v", dist, arch, err)
+			}
+		}
+	}
+	return nil
+}
+
+// suffixImagePath turns "foo.aci" into "foo-bookworm-amd64.aci".
+func suffixImagePath(image, dist, arch string) string {
+	ext := filepath.Ext(image)
+	base := strings.TrimSuffix(image, ext)
+	return fmt.Sprintf("%v-%v-%v%v", base, dist, arch, ext)
+}